@@ -0,0 +1,626 @@
+package jutil
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Marshaler can be implemented by a value to override the default JSON
+// encoding performed by Append and Encode, in lockstep with Lengther for
+// sizing. Values that only implement Lengther have no way to report their
+// own bytes, so Append and Encode return an error for them; implement
+// AppendJSON as well to support encoding.
+type Marshaler interface {
+	Lengther
+
+	// AppendJSON appends the JSON representation of the value to dst and
+	// returns the extended slice.
+	AppendJSON(dst []byte) ([]byte, error)
+}
+
+// Encoder writes the JSON representation of values to an output stream,
+// mirroring the API of json.Encoder but backed by Append instead of
+// encoding/json, so each Encode call allocates at most once.
+type Encoder struct {
+	w    io.Writer
+	buf  []byte
+	opts Options
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetEscapeHTML specifies whether problematic HTML characters should be
+// escaped inside JSON quoted strings, matching json.Encoder.SetEscapeHTML.
+// It defaults to false; when enabled, LengthWith counts the extra escapes, so
+// the output is still sized in one step.
+func (e *Encoder) SetEscapeHTML(on bool) {
+	e.opts.EscapeHTML = on
+}
+
+// SetOptions replaces the Options used to size and write subsequent values,
+// giving access to SortMapKeys and TimeFormat in addition to EscapeHTML.
+func (e *Encoder) SetOptions(opts Options) {
+	e.opts = opts
+}
+
+// Encode writes the JSON representation of v to the stream.
+func (e *Encoder) Encode(v interface{}) (err error) {
+	e.buf, err = AppendWith(e.buf[:0], v, e.opts)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+// Encode writes the JSON representation of v to w: it builds the output
+// with Append, which sizes a single buffer with Length, then hands that
+// buffer to w in one Write call.
+func Encode(w io.Writer, v interface{}) error {
+	return EncodeWith(w, v, Options{})
+}
+
+// EncodeWith writes the JSON representation of v to w like Encode, but
+// applies opts the way LengthWith does.
+func EncodeWith(w io.Writer, v interface{}, opts Options) error {
+	b, err := AppendWith(nil, v, opts)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Append appends the JSON representation of v to dst and returns the
+// extended buffer. Append calls Length first to grow dst by exactly the
+// right amount, then writes v in a second pass with no further
+// reallocation, so len(result)-len(dst) == Length(v) holds as a checked
+// invariant.
+func Append(dst []byte, v interface{}) ([]byte, error) {
+	return AppendWith(dst, v, Options{})
+}
+
+// AppendWith appends the JSON representation of v to dst like Append, but
+// applies opts the way LengthWith does; the size invariant is checked
+// against LengthWith(v, opts).
+func AppendWith(dst []byte, v interface{}, opts Options) ([]byte, error) {
+	n, err := LengthWith(v, opts)
+	if err != nil {
+		return dst, err
+	}
+
+	dst = grow(dst, n)
+	off := len(dst)
+
+	dst, err = appendValue(dst, v, opts)
+	if err != nil {
+		return dst, err
+	}
+
+	if got := len(dst) - off; got != n {
+		return dst, fmt.Errorf("jutil: LengthWith and AppendWith disagree on size: Length=%d Append=%d", n, got)
+	}
+
+	return dst, nil
+}
+
+func grow(dst []byte, n int) []byte {
+	if avail := cap(dst) - len(dst); avail < n {
+		b := make([]byte, len(dst), len(dst)+n)
+		copy(b, dst)
+		dst = b
+	}
+	return dst
+}
+
+func appendValue(dst []byte, v interface{}, opts Options) ([]byte, error) {
+	if v == nil {
+		return appendNull(dst), nil
+	}
+
+	switch x := v.(type) {
+	case bool:
+		return appendBool(dst, x), nil
+
+	case int:
+		return appendInt(dst, int64(x)), nil
+
+	case int8:
+		return appendInt(dst, int64(x)), nil
+
+	case int16:
+		return appendInt(dst, int64(x)), nil
+
+	case int32:
+		return appendInt(dst, int64(x)), nil
+
+	case int64:
+		return appendInt(dst, x), nil
+
+	case uint:
+		return appendUint(dst, uint64(x)), nil
+
+	case uint8:
+		return appendUint(dst, uint64(x)), nil
+
+	case uint16:
+		return appendUint(dst, uint64(x)), nil
+
+	case uint32:
+		return appendUint(dst, uint64(x)), nil
+
+	case uint64:
+		return appendUint(dst, x), nil
+
+	case float32:
+		return appendFloat(dst, float64(x)), nil
+
+	case float64:
+		return appendFloat(dst, x), nil
+
+	case string:
+		return appendString(dst, x, opts), nil
+
+	case []byte:
+		return appendBytes(dst, x), nil
+
+	case map[string]interface{}:
+		return appendMapStringInterface(dst, x, opts)
+
+	case []interface{}:
+		return appendSliceInterface(dst, x, opts)
+
+	case time.Time:
+		layout := opts.TimeFormat
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		return appendString(dst, x.Format(layout), opts), nil
+
+	case time.Duration:
+		return appendInt(dst, int64(x)), nil
+
+	case big.Int:
+		return appendBigInt(dst, &x)
+
+	case *big.Int:
+		return appendBigInt(dst, x)
+
+	case big.Float:
+		return appendBigFloat(dst, &x, opts)
+
+	case *big.Float:
+		return appendBigFloat(dst, x, opts)
+
+	case Marshaler:
+		return x.AppendJSON(dst)
+
+	case Lengther:
+		return dst, fmt.Errorf("jutil: %T implements Lengther but not Marshaler, so Append/Encode cannot write it; implement AppendJSON to support encoding", x)
+
+	case json.Number:
+		return append(dst, x...), nil
+
+	case json.Marshaler:
+		b, err := x.MarshalJSON()
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, b...), nil
+
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		if err != nil {
+			return dst, err
+		}
+		return appendString(dst, string(b), opts), nil
+
+	default:
+		return appendReflectValue(dst, reflect.ValueOf(v), opts)
+	}
+}
+
+func appendReflectValue(dst []byte, v reflect.Value, opts Options) (_ []byte, err error) {
+	if !v.IsValid() {
+		return dst, &json.UnsupportedValueError{Value: v, Str: "the value is invalid"}
+	}
+
+	switch t := v.Type(); t.Kind() {
+	case reflect.Struct:
+		return appendStruct(dst, t, v, opts)
+
+	case reflect.Map:
+		return appendMap(dst, v, opts)
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return appendBytes(dst, v.Bytes()), nil
+		}
+		return appendArray(dst, v, opts)
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return appendNull(dst), nil
+		}
+		elem := v.Elem()
+		if !elem.CanInterface() {
+			return dst, fmt.Errorf("reflect: cannot call Interface on %v", elem)
+		}
+		return appendValue(dst, elem.Interface(), opts)
+
+	case reflect.Bool:
+		return appendBool(dst, v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(dst, v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendUint(dst, v.Uint()), nil
+
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(dst, v.Float()), nil
+
+	case reflect.String:
+		return appendString(dst, v.String(), opts), nil
+
+	case reflect.Array:
+		return appendArray(dst, v, opts)
+
+	default:
+		return dst, &json.UnsupportedTypeError{Type: t}
+	}
+}
+
+func appendNull(dst []byte) []byte {
+	return append(dst, "null"...)
+}
+
+func appendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, "true"...)
+	}
+	return append(dst, "false"...)
+}
+
+func appendInt(dst []byte, v int64) []byte {
+	return strconv.AppendInt(dst, v, 10)
+}
+
+func appendUint(dst []byte, v uint64) []byte {
+	return strconv.AppendUint(dst, v, 10)
+}
+
+func appendFloat(dst []byte, v float64) []byte {
+	return strconv.AppendFloat(dst, v, 'g', -1, 64)
+}
+
+// appendString writes s the way encoding/json would render it, dispatching
+// to the ASCII-only fast path when opts.ASCIIOnly is set and to the
+// UTF-8-aware, spec-compliant path otherwise, mirroring jsonLenStringOpts.
+func appendString(dst []byte, s string, opts Options) []byte {
+	if opts.ASCIIOnly {
+		return appendStringFast(dst, s, opts.EscapeHTML)
+	}
+	return appendStringSafe(dst, s, opts.EscapeHTML)
+}
+
+// appendStringFast is the historical implementation: it copies every byte
+// through unescaped except for the named escapes and, when escapeHTML is
+// set, '<', '>' and '&'. It never escapes other control bytes or replaces
+// invalid UTF-8, matching jsonLenStringFast's under-counting.
+func appendStringFast(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		case '\f':
+			dst = append(dst, '\\', 'f')
+		case '/':
+			dst = append(dst, '\\', '/')
+		case '<', '>', '&':
+			if escapeHTML {
+				dst = append(dst, '\\', 'u', '0', '0', hexDigit(c>>4), hexDigit(c&0xF))
+			} else {
+				dst = append(dst, c)
+			}
+		case '\xe2':
+			// U+2028/U+2029, see the matching case in jsonLenStringFast.
+			if i+2 < len(s) && s[i+1] == '\x80' && s[i+2] == '\xa8' {
+				dst = append(dst, '\\', 'u', '2', '0', '2', '8')
+				i += 2
+			} else if i+2 < len(s) && s[i+1] == '\x80' && s[i+2] == '\xa9' {
+				dst = append(dst, '\\', 'u', '2', '0', '2', '9')
+				i += 2
+			} else {
+				dst = append(dst, c)
+			}
+		default:
+			dst = append(dst, c)
+		}
+	}
+
+	return append(dst, '"')
+}
+
+// appendStringSafe writes s the way encoding/json actually encodes it,
+// mirroring jsonLenStringSafe byte for byte: control bytes below 0x20 that
+// aren't named escapes become a \u00XX sequence, invalid UTF-8 bytes are
+// replaced one-for-one by U+FFFD, and U+2028/U+2029 are always escaped
+// regardless of EscapeHTML.
+func appendStringSafe(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '<', '>', '&':
+				if escapeHTML {
+					dst = append(dst, '\\', 'u', '0', '0', hexDigit(c>>4), hexDigit(c&0xF))
+				} else {
+					dst = append(dst, c)
+				}
+			default:
+				if c < 0x20 {
+					dst = append(dst, '\\', 'u', '0', '0', hexDigit(c>>4), hexDigit(c&0xF))
+				} else {
+					dst = append(dst, c)
+				}
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, "\ufffd"...)
+		case r == '\u2028':
+			dst = append(dst, `\u2028`...)
+		case r == '\u2029':
+			dst = append(dst, `\u2029`...)
+		default:
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+
+	return append(dst, '"')
+}
+
+func hexDigit(v byte) byte {
+	const digits = "0123456789abcdef"
+	return digits[v]
+}
+
+func appendBigInt(dst []byte, x *big.Int) ([]byte, error) {
+	b, err := x.MarshalJSON()
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
+
+func appendBigFloat(dst []byte, x *big.Float, opts Options) ([]byte, error) {
+	b, err := x.MarshalText()
+	if err != nil {
+		return dst, err
+	}
+	return appendString(dst, string(b), opts), nil
+}
+
+func appendBytes(dst []byte, b []byte) []byte {
+	dst = append(dst, '"')
+	n := len(dst)
+	dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(b)))...)
+	base64.StdEncoding.Encode(dst[n:], b)
+	return append(dst, '"')
+}
+
+func appendArray(dst []byte, v reflect.Value, opts Options) (_ []byte, err error) {
+	dst = append(dst, '[')
+
+	for i, j := 0, v.Len(); i != j; i++ {
+		if i != 0 {
+			dst = append(dst, ',')
+		}
+
+		elem := v.Index(i)
+		if !elem.CanInterface() {
+			return dst, fmt.Errorf("reflect: cannot call Interface on value %v", elem)
+		}
+		if dst, err = appendValue(dst, elem.Interface(), opts); err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, ']'), nil
+}
+
+func appendMap(dst []byte, v reflect.Value, opts Options) (_ []byte, err error) {
+	dst = append(dst, '{')
+
+	keys := v.MapKeys()
+	if opts.SortMapKeys {
+		sortMapKeys(keys)
+	}
+
+	for i, k := range keys {
+		if !k.CanInterface() {
+			return dst, fmt.Errorf("reflect: cannot call Interface on value %v", k)
+		}
+
+		if i != 0 {
+			dst = append(dst, ',')
+		}
+
+		if dst, err = appendMapKey(dst, k.Interface(), opts); err != nil {
+			return dst, err
+		}
+
+		dst = append(dst, ':')
+
+		elem := v.MapIndex(k)
+		if !elem.CanInterface() {
+			return dst, fmt.Errorf("reflect: cannot call Interface on value %v", elem)
+		}
+		if dst, err = appendValue(dst, elem.Interface(), opts); err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, '}'), nil
+}
+
+// appendMapKey writes k the way encoding/json renders a map key: always a
+// quoted string, even when k is an integer or an encoding.TextMarshaler.
+func appendMapKey(dst []byte, k interface{}, opts Options) ([]byte, error) {
+	switch x := k.(type) {
+	case string:
+		return appendString(dst, x, opts), nil
+
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		if err != nil {
+			return dst, err
+		}
+		return appendString(dst, string(b), opts), nil
+	}
+
+	v := reflect.ValueOf(k)
+
+	switch v.Kind() {
+	case reflect.String:
+		return appendString(dst, v.String(), opts), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendString(dst, strconv.FormatInt(v.Int(), 10), opts), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendString(dst, strconv.FormatUint(v.Uint(), 10), opts), nil
+
+	default:
+		return dst, &json.UnsupportedTypeError{Type: v.Type()}
+	}
+}
+
+func appendStruct(dst []byte, t reflect.Type, v reflect.Value, opts Options) (_ []byte, err error) {
+	dst = append(dst, '{')
+	start := len(dst)
+
+	for _, f := range LookupStruct(t) {
+		fv := v.FieldByIndex(f.Index)
+
+		if f.Omitempty && isEmptyValue(fv) {
+			continue
+		}
+
+		if !fv.CanInterface() {
+			return dst, fmt.Errorf("reflect: cannot call Interface on %v", fv)
+		}
+
+		if len(dst) != start {
+			dst = append(dst, ',')
+		}
+
+		dst = appendStringFast(dst, f.Name, false)
+		dst = append(dst, ':')
+
+		if dst, err = appendValue(dst, fv.Interface(), opts); err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, '}'), nil
+}
+
+func appendSliceInterface(dst []byte, s []interface{}, opts Options) (_ []byte, err error) {
+	dst = append(dst, '[')
+
+	for i, v := range s {
+		if i != 0 {
+			dst = append(dst, ',')
+		}
+		if dst, err = appendValue(dst, v, opts); err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, ']'), nil
+}
+
+func appendMapStringInterface(dst []byte, m map[string]interface{}, opts Options) (_ []byte, err error) {
+	dst = append(dst, '{')
+	start := len(dst)
+
+	keys := make([]string, 0, len(m))
+	if opts.SortMapKeys {
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if len(dst) != start {
+				dst = append(dst, ',')
+			}
+			dst = appendString(dst, k, opts)
+			dst = append(dst, ':')
+			if dst, err = appendValue(dst, m[k], opts); err != nil {
+				return dst, err
+			}
+		}
+
+		return append(dst, '}'), nil
+	}
+
+	for k, v := range m {
+		if len(dst) != start {
+			dst = append(dst, ',')
+		}
+
+		dst = appendString(dst, k, opts)
+		dst = append(dst, ':')
+
+		if dst, err = appendValue(dst, v, opts); err != nil {
+			return dst, err
+		}
+	}
+
+	return append(dst, '}'), nil
+}
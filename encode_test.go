@@ -0,0 +1,89 @@
+package jutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type lengtherOnly struct{ n int }
+
+func (v lengtherOnly) LengthJSON() int { return v.n }
+
+// checkAppendMatchesLength asserts that Append produces exactly Length(v)
+// bytes of valid JSON, not merely that the two agree with each other.
+func checkAppendMatchesLength(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	n, err := Length(v)
+	if err != nil {
+		t.Fatalf("Length(%#v) = %v", v, err)
+	}
+
+	b, err := Append(nil, v)
+	if err != nil {
+		t.Fatalf("Append(%#v) = %v", v, err)
+	}
+
+	if len(b) != n {
+		t.Fatalf("Length(%#v) = %d, Append produced %d bytes (%q)", v, n, len(b), b)
+	}
+
+	if !json.Valid(b) {
+		t.Fatalf("Append(%#v) produced invalid JSON: %s", v, b)
+	}
+
+	return b
+}
+
+func TestAppendMatchesLengthBytes(t *testing.T) {
+	for _, b := range [][]byte{nil, {}, []byte("x"), []byte("hello world")} {
+		checkAppendMatchesLength(t, b)
+	}
+}
+
+func TestAppendMatchesLengthNil(t *testing.T) {
+	checkAppendMatchesLength(t, nil)
+}
+
+func TestAppendLengtherOnlyUnsupported(t *testing.T) {
+	v := lengtherOnly{n: 999}
+
+	if _, err := Append(nil, v); err == nil {
+		t.Fatalf("Append(%#v) = nil error, want an error since it only implements Lengther", v)
+	}
+}
+
+func TestEncoderEncode(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	if err := enc.Encode("<script>"); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	if got, want := buf.String(), `"<script>"`; got != want {
+		t.Fatalf("Encode() wrote %s, want %s", got, want)
+	}
+
+	buf.Reset()
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode("<script>"); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Fatalf("Encode() with SetEscapeHTML(true) produced invalid JSON: %s", buf.Bytes())
+	}
+	var got string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil || got != "<script>" {
+		t.Fatalf("Encode() round-trip = %q, %v, want %q, nil", got, err, "<script>")
+	}
+
+	buf.Reset()
+	enc.SetOptions(Options{SortMapKeys: true})
+	if err := enc.Encode(map[string]int{"b": 2, "a": 1}); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	if got, want := buf.String(), `{"a":1,"b":2}`; got != want {
+		t.Fatalf("Encode() with SortMapKeys wrote %s, want %s", got, want)
+	}
+}
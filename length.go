@@ -2,11 +2,16 @@ package jutil
 
 import (
 	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Lengther can be implemented by a value to override the default length
@@ -16,10 +21,47 @@ type Lengther interface {
 	LengthJSON() int
 }
 
+// Options controls how Length measures a value, letting callers mirror
+// non-default behaviors of the standard encoding/json package.
+type Options struct {
+	// EscapeHTML specifies whether '<', '>', and '&' are escaped as \uXXXX
+	// sequences inside strings, matching json.Encoder.SetEscapeHTML. It
+	// defaults to false, matching Length's historical behavior of
+	// measuring raw byte length. The U+2028/U+2029 line/paragraph
+	// separators are always escaped, regardless of this option, matching
+	// encoding/json's unconditional behavior for them.
+	EscapeHTML bool
+
+	// SortMapKeys specifies whether keys of reflect-based maps are walked
+	// in sorted order, matching the default behavior of encoding/json. It
+	// does not change the measured length, but keeps Options a single
+	// source of truth for callers that also encode the value afterwards.
+	SortMapKeys bool
+
+	// TimeFormat overrides the layout used to measure time.Time values. It
+	// defaults to time.RFC3339Nano, matching time.Time.MarshalJSON.
+	TimeFormat string
+
+	// ASCIIOnly opts into the historical fast path: strings are assumed to
+	// contain no raw control bytes other than the named escapes and no
+	// invalid UTF-8. It under-counts any string that violates that
+	// assumption, so only set it when the input is known to be clean ASCII
+	// and the extra speed matters.
+	ASCIIOnly bool
+}
+
 // Length computes the length of the JSON representation of a value of
 // arbitrary type, it's ~10x faster than serializing the content with the
-// standard json package and avoid the extra memory allocations.
-func Length(v interface{}) (n int, err error) {
+// standard json package and avoid the extra memory allocations. It is
+// equivalent to calling LengthWith with the zero value of Options.
+func Length(v interface{}) (int, error) {
+	return LengthWith(v, Options{})
+}
+
+// LengthWith computes the length of the JSON representation of v like
+// Length, but applies opts to decide how HTML characters, map keys, and
+// time.Time values are measured.
+func LengthWith(v interface{}, opts Options) (n int, err error) {
 	var b []byte
 
 	if v == nil {
@@ -70,16 +112,34 @@ func Length(v interface{}) (n int, err error) {
 		n = jsonLenFloat(float64(x))
 
 	case string:
-		n = jsonLenString(x)
+		n = jsonLenStringOpts(x, opts)
 
 	case []byte:
 		n = jsonLenBytes(x)
 
 	case map[string]interface{}:
-		n, err = jsonLenMapStringInterface(x)
+		n, err = jsonLenMapStringInterface(x, opts)
 
 	case []interface{}:
-		n, err = jsonLenSliceInterface(x)
+		n, err = jsonLenSliceInterface(x, opts)
+
+	case time.Time:
+		n = jsonLenTime(x, opts)
+
+	case time.Duration:
+		n = jsonLenInt(int64(x))
+
+	case big.Int:
+		n, err = jsonLenBigInt(&x)
+
+	case *big.Int:
+		n, err = jsonLenBigInt(x)
+
+	case big.Float:
+		n, err = jsonLenBigFloat(&x, opts)
+
+	case *big.Float:
+		n, err = jsonLenBigFloat(x, opts)
 
 	case Lengther:
 		n = x.LengthJSON()
@@ -94,17 +154,17 @@ func Length(v interface{}) (n int, err error) {
 
 	case encoding.TextMarshaler:
 		if b, err = x.MarshalText(); err == nil {
-			n = jsonLenString(string(b))
+			n = jsonLenStringOpts(string(b), opts)
 		}
 
 	default:
-		n, err = jsonLenV(reflect.ValueOf(v))
+		n, err = jsonLenV(reflect.ValueOf(v), opts)
 	}
 
 	return
 }
 
-func jsonLenV(v reflect.Value) (n int, err error) {
+func jsonLenV(v reflect.Value, opts Options) (n int, err error) {
 	if !v.IsValid() {
 		err = &json.UnsupportedValueError{Value: v, Str: "the value is invalid"}
 		return
@@ -112,16 +172,16 @@ func jsonLenV(v reflect.Value) (n int, err error) {
 
 	switch t := v.Type(); t.Kind() {
 	case reflect.Struct:
-		n, err = jsonLenStruct(t, v)
+		n, err = jsonLenStruct(t, v, opts)
 
 	case reflect.Map:
-		n, err = jsonLenMap(v)
+		n, err = jsonLenMap(v, opts)
 
 	case reflect.Slice:
 		if t.Elem().Kind() == reflect.Uint8 {
 			n = jsonLenBytes(v.Bytes()) // []byte
 		} else {
-			n, err = jsonLenArray(v)
+			n, err = jsonLenArray(v, opts)
 		}
 
 	case reflect.Ptr, reflect.Interface:
@@ -133,7 +193,7 @@ func jsonLenV(v reflect.Value) (n int, err error) {
 				err = fmt.Errorf("reflect: cannot call Interface on %v", elem)
 				return
 			}
-			n, err = Length(elem.Interface())
+			n, err = LengthWith(elem.Interface(), opts)
 		}
 
 	case reflect.Bool:
@@ -149,10 +209,10 @@ func jsonLenV(v reflect.Value) (n int, err error) {
 		n = jsonLenFloat(v.Float())
 
 	case reflect.String:
-		n = jsonLenString(v.String())
+		n = jsonLenStringOpts(v.String(), opts)
 
 	case reflect.Array:
-		n, err = jsonLenArray(v)
+		n, err = jsonLenArray(v, opts)
 
 	default:
 		err = &json.UnsupportedTypeError{Type: t}
@@ -202,13 +262,38 @@ func jsonLenFloat(v float64) (n int) {
 	return len(strconv.AppendFloat(b[:0], v, 'g', -1, 64))
 }
 
+// jsonLenString measures s the way Length has always measured it: HTML
+// characters are not escaped, using jsonLenStringFast whatever the Options.
+// It is kept for callers, such as struct field names, that are always a
+// clean, control-byte-free ASCII identifier.
 func jsonLenString(s string) (n int) {
-	if len(s) < 100 {
+	return jsonLenStringFast(s, false)
+}
+
+// jsonLenStringOpts measures s as encoding/json would render it, dispatching
+// to the ASCII-only fast path when opts.ASCIIOnly is set and to the
+// UTF-8-aware, spec-compliant path otherwise.
+func jsonLenStringOpts(s string, opts Options) (n int) {
+	if opts.ASCIIOnly {
+		return jsonLenStringFast(s, opts.EscapeHTML)
+	}
+	return jsonLenStringSafe(s, opts.EscapeHTML)
+}
+
+// jsonLenStringFast is the historical implementation: it counts the named
+// escapes, U+2028/U+2029 and, when escapeHTML is set, '<', '>' and '&', but
+// under-counts raw control bytes (other than the named ones) and invalid
+// UTF-8.
+// It remains available, opt-in, through Options.ASCIIOnly for callers who
+// know their input is clean and want to avoid the rune-by-rune walk that
+// jsonLenStringSafe performs.
+func jsonLenStringFast(s string, escapeHTML bool) (n int) {
+	if !escapeHTML && len(s) < 100 {
 		// This is an optimization for short strings, most of them will not
 		// contain any escaped bytes and performances won't suffer from checking
 		// the string a couple of times because it will fit into a couple of
 		// cachelines.
-		const escapedBytes = "/\"\\\n\t\r\v\b\f"
+		const escapedBytes = "/\"\\\n\t\r\b\f"
 
 		switch len(s) {
 		case 0:
@@ -224,6 +309,12 @@ func jsonLenString(s string) (n int) {
 					goto slowPath
 				}
 			}
+			// 0xE2 is the lead byte of U+2028/U+2029, which encoding/json
+			// escapes unconditionally; fall back to the byte-by-byte walk
+			// to measure them rather than risk under-counting.
+			if strings.IndexByte(s, '\xe2') >= 0 {
+				goto slowPath
+			}
 		}
 
 		return 2 + len(s)
@@ -232,20 +323,109 @@ func jsonLenString(s string) (n int) {
 slowPath:
 	for i := range s {
 		switch s[i] {
-		case '\n', '\t', '\r', '\v', '\b', '\f', '\\', '/', '"':
+		case '\n', '\t', '\r', '\b', '\f', '\\', '/', '"':
 			n++
+		case '<', '>', '&':
+			if escapeHTML {
+				n += 5 // 1 byte becomes the 6-byte \u00XX sequence
+			}
+		case '\xe2':
+			// U+2028 (line separator) and U+2029 (paragraph separator) both
+			// encode as 0xE2 0x80 0xA8/0xA9; encoding/json escapes them
+			// unconditionally, regardless of EscapeHTML. The 3 raw bytes
+			// become the 6-byte escape sequence, a delta of 3.
+			if i+2 < len(s) && s[i+1] == '\x80' && (s[i+2] == '\xa8' || s[i+2] == '\xa9') {
+				n += 3
+			}
 		}
 	}
 
 	return n + 2 + len(s)
 }
 
+// jsonLenStringSafe measures s the way encoding/json actually encodes it:
+// control bytes below 0x20 that aren't named escapes become a 6-byte
+// \u00XX sequence, invalid UTF-8 bytes are replaced one-for-one by the
+// 3-byte U+FFFD replacement rune, and U+2028/U+2029 are always escaped
+// regardless of EscapeHTML, matching encoding/json.
+func jsonLenStringSafe(s string, escapeHTML bool) (n int) {
+	n = 2 // the surrounding quotes
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c < utf8.RuneSelf {
+			switch c {
+			case '\n', '\t', '\r', '\b', '\f', '\\', '"':
+				n += 2
+			case '<', '>', '&':
+				if escapeHTML {
+					n += 6
+				} else {
+					n++
+				}
+			default:
+				if c < 0x20 {
+					n += 6 // \u00XX
+				} else {
+					n++
+				}
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			n += 3 // the invalid byte is replaced by U+FFFD
+		case r == '\u2028' || r == '\u2029':
+			n += 6
+		default:
+			n += size
+		}
+		i += size
+	}
+
+	return n
+}
+
+func jsonLenTime(t time.Time, opts Options) (n int) {
+	// Matches time.Time.MarshalJSON, which quotes the RFC3339Nano form, but
+	// avoids the intermediate []byte it allocates just to measure it.
+	layout := opts.TimeFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return jsonLenStringOpts(t.Format(layout), opts)
+}
+
+func jsonLenBigInt(x *big.Int) (n int, err error) {
+	var b []byte
+	if b, err = x.MarshalJSON(); err == nil {
+		n = len(b)
+	}
+	return
+}
+
+func jsonLenBigFloat(x *big.Float, opts Options) (n int, err error) {
+	// big.Float only implements encoding.TextMarshaler, so encoding/json
+	// renders it as a quoted string rather than a bare number.
+	var b []byte
+	if b, err = x.MarshalText(); err == nil {
+		n = jsonLenStringOpts(string(b), opts)
+	}
+	return
+}
+
 func jsonLenBytes(b []byte) (n int) {
-	// The standard json package uses base64 encoding for byte slices...
-	return 2 + ((len(b) * 4) / 3)
+	// The standard json package uses base64 encoding for byte slices; use
+	// the same formula the encoder itself relies on so Length and Append
+	// never disagree on size.
+	return 2 + base64.StdEncoding.EncodedLen(len(b))
 }
 
-func jsonLenArray(v reflect.Value) (n int, err error) {
+func jsonLenArray(v reflect.Value, opts Options) (n int, err error) {
 	var c int
 
 	for i, j := 0, v.Len(); i != j; i++ {
@@ -258,7 +438,7 @@ func jsonLenArray(v reflect.Value) (n int, err error) {
 			err = fmt.Errorf("reflect: cannot call Interface on value %v", elem)
 			return
 		}
-		if c, err = Length(elem.Interface()); err != nil {
+		if c, err = LengthWith(elem.Interface(), opts); err != nil {
 			return
 		}
 
@@ -269,11 +449,16 @@ func jsonLenArray(v reflect.Value) (n int, err error) {
 	return
 }
 
-func jsonLenMap(v reflect.Value) (n int, err error) {
+func jsonLenMap(v reflect.Value, opts Options) (n int, err error) {
 	var c1 int
 	var c2 int
 
-	for i, k := range v.MapKeys() {
+	keys := v.MapKeys()
+	if opts.SortMapKeys {
+		sortMapKeys(keys)
+	}
+
+	for i, k := range keys {
 		if !k.CanInterface() {
 			err = fmt.Errorf("reflect: cannot call Interface on value %v", k)
 			return
@@ -283,11 +468,16 @@ func jsonLenMap(v reflect.Value) (n int, err error) {
 			n++
 		}
 
-		if c1, err = Length(k.Interface()); err != nil {
+		if c1, err = jsonLenMapKey(k.Interface(), opts); err != nil {
 			return
 		}
 
-		if c2, err = Length(v.MapIndex(k).Interface()); err != nil {
+		elem := v.MapIndex(k)
+		if !elem.CanInterface() {
+			err = fmt.Errorf("reflect: cannot call Interface on value %v", elem)
+			return
+		}
+		if c2, err = LengthWith(elem.Interface(), opts); err != nil {
 			return
 		}
 
@@ -298,7 +488,65 @@ func jsonLenMap(v reflect.Value) (n int, err error) {
 	return
 }
 
-func jsonLenStruct(t reflect.Type, v reflect.Value) (n int, err error) {
+// jsonLenMapKey measures k as encoding/json would render a map key: always
+// a quoted string, even when k is an integer or a encoding.TextMarshaler.
+func jsonLenMapKey(k interface{}, opts Options) (int, error) {
+	switch x := k.(type) {
+	case string:
+		return jsonLenStringOpts(x, opts), nil
+
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		if err != nil {
+			return 0, err
+		}
+		return jsonLenStringOpts(string(b), opts), nil
+	}
+
+	v := reflect.ValueOf(k)
+
+	switch v.Kind() {
+	case reflect.String:
+		return jsonLenStringOpts(v.String(), opts), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return jsonLenStringOpts(strconv.FormatInt(v.Int(), 10), opts), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonLenStringOpts(strconv.FormatUint(v.Uint(), 10), opts), nil
+
+	default:
+		return 0, &json.UnsupportedTypeError{Type: v.Type()}
+	}
+}
+
+func sortMapKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		return mapKeyString(keys[i]) < mapKeyString(keys[j])
+	})
+}
+
+func mapKeyString(k reflect.Value) string {
+	switch x := k.Interface().(type) {
+	case string:
+		return x
+	case encoding.TextMarshaler:
+		if b, err := x.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	default:
+		return fmt.Sprint(k.Interface())
+	}
+}
+
+func jsonLenStruct(t reflect.Type, v reflect.Value, opts Options) (n int, err error) {
 	var c int
 	var s = LookupStruct(t)
 
@@ -313,7 +561,7 @@ func jsonLenStruct(t reflect.Type, v reflect.Value) (n int, err error) {
 			err = fmt.Errorf("reflect: cannot call Interface on %v", fv)
 			return
 		}
-		if c, err = Length(fv.Interface()); err != nil {
+		if c, err = LengthWith(fv.Interface(), opts); err != nil {
 			return
 		}
 
@@ -328,11 +576,11 @@ func jsonLenStruct(t reflect.Type, v reflect.Value) (n int, err error) {
 	return
 }
 
-func jsonLenSliceInterface(s []interface{}) (n int, err error) {
+func jsonLenSliceInterface(s []interface{}, opts Options) (n int, err error) {
 	var c int
 
 	for _, v := range s {
-		if c, err = Length(v); err != nil {
+		if c, err = LengthWith(v, opts); err != nil {
 			return
 		}
 		n += c
@@ -346,14 +594,31 @@ func jsonLenSliceInterface(s []interface{}) (n int, err error) {
 	return
 }
 
-func jsonLenMapStringInterface(m map[string]interface{}) (n int, err error) {
+func jsonLenMapStringInterface(m map[string]interface{}, opts Options) (n int, err error) {
 	var c int
 
-	for k, v := range m {
-		if c, err = Length(v); err != nil {
-			return
+	keys := make([]string, 0, len(m))
+	if opts.SortMapKeys {
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+
+	if opts.SortMapKeys {
+		for _, k := range keys {
+			if c, err = LengthWith(m[k], opts); err != nil {
+				return
+			}
+			n += jsonLenStringOpts(k, opts) + c + 1
+		}
+	} else {
+		for k, v := range m {
+			if c, err = LengthWith(v, opts); err != nil {
+				return
+			}
+			n += jsonLenStringOpts(k, opts) + c + 1
 		}
-		n += jsonLenString(k) + c + 1
 	}
 
 	if c = len(m); c > 1 {
@@ -0,0 +1,33 @@
+package jutil
+
+import "testing"
+
+// benchStrings covers the cases where the ASCII-only fast path and the
+// UTF-8-aware safe path diverge in cost: plain ASCII, named escapes,
+// control bytes requiring a \u00XX escape, and multi-byte UTF-8.
+var benchStrings = []string{
+	"the quick brown fox jumps over the lazy dog",
+	`line one\nline two\ttabbed "quoted"`,
+	"control\x01byte\x1fhere",
+	"héllo wörld こんにちは 世界",
+}
+
+func BenchmarkLengthStringFast(b *testing.B) {
+	opts := Options{ASCIIOnly: true}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range benchStrings {
+			jsonLenStringOpts(s, opts)
+		}
+	}
+}
+
+func BenchmarkLengthStringSafe(b *testing.B) {
+	opts := Options{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range benchStrings {
+			jsonLenStringOpts(s, opts)
+		}
+	}
+}
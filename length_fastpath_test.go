@@ -0,0 +1,25 @@
+package jutil
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// lengthFastPathValues covers the non-primitive fast-path types Length
+// special-cases: time.Time, time.Duration, and big.Int/big.Float, both by
+// value and by pointer.
+var lengthFastPathValues = []interface{}{
+	time.Date(2026, 7, 26, 0, 0, 0, 0, time.UTC),
+	24 * time.Hour,
+	*big.NewInt(123456789),
+	big.NewInt(123456789),
+	*big.NewFloat(3.14),
+	big.NewFloat(3.14),
+}
+
+func TestLengthFastPathTypes(t *testing.T) {
+	for _, v := range lengthFastPathValues {
+		checkAppendMatchesLength(t, v)
+	}
+}
@@ -0,0 +1,45 @@
+package jutil
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// stringSafeCases covers control bytes, named escapes, and multi-byte runes
+// that jsonLenStringSafe/appendStringSafe must measure and emit exactly the
+// way encoding/json does.
+var stringSafeCases = []string{
+	"plain",
+	"a\vb",
+	"tab\tnewline\ncarriage\rreturn",
+	"bell\abackspace\bformfeed\f",
+	"control\x01byte\x1fhere",
+	"quote\"slash/backslash\\",
+	"héllo wörld こんにちは 世界",
+}
+
+func TestAppendStringValidJSON(t *testing.T) {
+	for _, s := range stringSafeCases {
+		b := checkAppendMatchesLength(t, s)
+
+		if !json.Valid(b) {
+			t.Fatalf("Append(%q) produced invalid JSON: %s", s, b)
+		}
+
+		var got string
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v", b, err)
+		}
+		if got != s {
+			t.Fatalf("round-trip mismatch: got %q, want %q", got, s)
+		}
+
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("json.Marshal(%q) = %v", s, err)
+		}
+		if string(b) != string(want) {
+			t.Fatalf("Append(%q) = %s, encoding/json produced %s", s, b, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package jutil
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppendMatchesLengthHTMLEscaping(t *testing.T) {
+	for _, opts := range []Options{{}, {EscapeHTML: true}} {
+		for _, s := range []string{"<script>", "a & b", "plain"} {
+			n, err := LengthWith(s, opts)
+			if err != nil {
+				t.Fatalf("LengthWith(%q, %+v) = %v", s, opts, err)
+			}
+			b, err := AppendWith(nil, s, opts)
+			if err != nil {
+				t.Fatalf("AppendWith(%q, %+v) = %v", s, opts, err)
+			}
+			if len(b) != n {
+				t.Fatalf("LengthWith(%q, %+v) = %d, AppendWith produced %d bytes (%q)", s, opts, n, len(b), b)
+			}
+		}
+	}
+}
+
+func TestLineParagraphSeparatorsAlwaysEscaped(t *testing.T) {
+	s := "line\u2028sep\u2029end"
+
+	for _, opts := range []Options{{}, {EscapeHTML: true}} {
+		n, err := LengthWith(s, opts)
+		if err != nil {
+			t.Fatalf("LengthWith(%q, %+v) = %v", s, opts, err)
+		}
+
+		b, err := AppendWith(nil, s, opts)
+		if err != nil {
+			t.Fatalf("AppendWith(%q, %+v) = %v", s, opts, err)
+		}
+		if len(b) != n {
+			t.Fatalf("LengthWith(%q, %+v) = %d, AppendWith produced %d bytes (%q)", s, opts, n, len(b), b)
+		}
+
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("json.Marshal(%q) = %v", s, err)
+		}
+		if string(b) != string(want) {
+			t.Fatalf("AppendWith(%q, %+v) = %s, encoding/json produced %s", s, opts, b, want)
+		}
+	}
+}
+
+func TestAppendMatchesLengthIntMapKeys(t *testing.T) {
+	m := map[int]string{1: "a", 2: "b", 3: "c"}
+
+	for _, opts := range []Options{{}, {SortMapKeys: true}} {
+		n, err := LengthWith(m, opts)
+		if err != nil {
+			t.Fatalf("LengthWith(%+v) = %v", opts, err)
+		}
+		b, err := AppendWith(nil, m, opts)
+		if err != nil {
+			t.Fatalf("AppendWith(%+v) = %v", opts, err)
+		}
+		if len(b) != n {
+			t.Fatalf("LengthWith(map, %+v) = %d, AppendWith produced %d bytes (%q)", opts, n, len(b), b)
+		}
+	}
+}
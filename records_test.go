@@ -0,0 +1,107 @@
+package jutil
+
+import "testing"
+
+type recordRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type otherRow struct {
+	Value bool `json:"value"`
+}
+
+func TestLengthRecordsNil(t *testing.T) {
+	n, err := LengthRecords(nil)
+	if err != nil {
+		t.Fatalf("LengthRecords(nil) = %v", err)
+	}
+	if want := jsonLenNull(); n != want {
+		t.Fatalf("LengthRecords(nil) = %d, want %d", n, want)
+	}
+}
+
+func TestLengthRecordsEmpty(t *testing.T) {
+	n, err := LengthRecords([]recordRow{})
+	if err != nil {
+		t.Fatalf("LengthRecords([]recordRow{}) = %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("LengthRecords([]recordRow{}) = %d, want 2", n)
+	}
+}
+
+func TestLengthRecordsIterNilRow(t *testing.T) {
+	rows := []interface{}{recordRow{ID: 1, Name: "a"}, nil}
+	i := 0
+	n, err := LengthRecordsIter(func() (interface{}, bool) {
+		if i >= len(rows) {
+			return nil, false
+		}
+		row := rows[i]
+		i++
+		return row, true
+	})
+	if err != nil {
+		t.Fatalf("LengthRecordsIter = %v", err)
+	}
+
+	single, err := LengthRecords([]recordRow{{ID: 1, Name: "a"}})
+	if err != nil {
+		t.Fatalf("LengthRecords = %v", err)
+	}
+	// single is "[" + row + "]"; replace the closing "]" with "," + "null" + "]".
+	want := single - 1 + 1 + jsonLenNull() + 1
+
+	if n != want {
+		t.Fatalf("LengthRecordsIter = %d, want %d", n, want)
+	}
+}
+
+func TestLengthRecordsIterMismatchedTypes(t *testing.T) {
+	rows := []interface{}{recordRow{ID: 1, Name: "a"}, otherRow{Value: true}}
+	i := 0
+
+	_, err := LengthRecordsIter(func() (interface{}, bool) {
+		if i >= len(rows) {
+			return nil, false
+		}
+		row := rows[i]
+		i++
+		return row, true
+	})
+	if err == nil {
+		t.Fatalf("LengthRecordsIter with mismatched row types = nil error, want an error")
+	}
+}
+
+func TestLengthRecordsWithOptionsAndPointerSlice(t *testing.T) {
+	rows := []*recordRow{{ID: 1, Name: "<a>"}, {ID: 2, Name: "<b>"}}
+	opts := Options{EscapeHTML: true}
+
+	n, err := LengthRecordsWith(rows, opts)
+	if err != nil {
+		t.Fatalf("LengthRecordsWith(%+v) = %v", opts, err)
+	}
+
+	var got []int
+	for _, row := range rows {
+		c, err := LengthWith(*row, opts)
+		if err != nil {
+			t.Fatalf("LengthWith(%+v) = %v", opts, err)
+		}
+		got = append(got, c)
+	}
+
+	want := 2 // the enclosing '[' and ']'
+	for i, c := range got {
+		if i != 0 {
+			want++ // the separating ','
+		}
+		want += c
+	}
+
+	if n != want {
+		t.Fatalf("LengthRecordsWith(pointer slice, %+v) = %d, want %d", opts, n, want)
+	}
+}